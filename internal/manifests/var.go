@@ -2,27 +2,107 @@ package manifests
 
 import (
 	"fmt"
+	"regexp"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/labels"
 )
 
 const (
-	containerImage = "docker.io/grafana/loki:2.1.0"
-	gossipPort     = 7946
-	httpPort       = 3100
-	grpcPort       = 9095
+	defaultContainerImage = "docker.io/grafana/loki:2.1.0"
+	defaultGossipPort     = 7946
+	defaultHTTPPort       = 3100
+	defaultGRPCPort       = 9095
+	defaultProvider       = "openshift"
 )
 
-func commonLabels(stackName string) map[string]string {
-	return map[string]string{
-		"app.kubernetes.io/name":     "loki",
-		"app.kubernetes.io/provider": "openshift",
-		"loki.grafana.com/name":      stackName,
+// Ports holds the container ports the generated manifests expose.
+type Ports struct {
+	HTTP   int
+	GRPC   int
+	Gossip int
+}
+
+// Options configures the image, ports and label/annotation scheme. It is
+// taken by ComponentLabels; the service-name helpers do not take it, and no
+// pod/deployment builders exist in this package. Use DefaultOptions for the historical
+// OpenShift defaults and override only what a given deployment needs, e.g.
+// a non-OpenShift cluster or a newer Loki version.
+type Options struct {
+	Image            string
+	ImagePullPolicy  corev1.PullPolicy
+	Ports            Ports
+	Provider         string
+	ExtraLabels      map[string]string
+	ExtraAnnotations map[string]string
+}
+
+// DefaultOptions returns the Options this package used to hard-code, for
+// callers that don't need to customize the image, ports, or labels.
+func DefaultOptions() Options {
+	return Options{
+		Image:           defaultContainerImage,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Ports: Ports{
+			HTTP:   defaultHTTPPort,
+			GRPC:   defaultGRPCPort,
+			Gossip: defaultGossipPort,
+		},
+		Provider: defaultProvider,
+	}
+}
+
+// imageRefPattern matches a container image reference of the form
+// [registry[:port]/]repository[:tag][@digest], which is all Validate checks
+// for; it is not a full implementation of the distribution reference
+// grammar. The registry prefix is matched separately from the rest so a
+// self-hosted registry address like localhost:5000/repo:tag isn't mistaken
+// for a second, invalid tag separator.
+var imageRefPattern = regexp.MustCompile(`^(?:[a-zA-Z0-9.-]+(?::\d+)?/)?[a-zA-Z0-9._/-]+(?::[a-zA-Z0-9._-]+)?(?:@sha256:[a-fA-F0-9]{64})?$`)
+
+// Validate checks that o describes a usable deployment: Image must parse as
+// a container image reference, and the HTTP, GRPC and Gossip ports must all
+// be set and mutually distinct.
+func (o Options) Validate() error {
+	if o.Image == "" || !imageRefPattern.MatchString(o.Image) {
+		return fmt.Errorf("invalid image reference: %q", o.Image)
+	}
+
+	named := map[string]int{
+		"http":   o.Ports.HTTP,
+		"grpc":   o.Ports.GRPC,
+		"gossip": o.Ports.Gossip,
+	}
+	seen := make(map[int]string, len(named))
+	for _, name := range [...]string{"http", "grpc", "gossip"} {
+		port := named[name]
+		if port <= 0 {
+			return fmt.Errorf("%s port must be set", name)
+		}
+		if other, ok := seen[port]; ok {
+			return fmt.Errorf("%s and %s ports conflict on %d", other, name, port)
+		}
+		seen[port] = name
+	}
+
+	return nil
+}
+
+func commonLabels(stackName string, opts Options) map[string]string {
+	merged := make(map[string]string, len(opts.ExtraLabels)+3)
+	for k, v := range opts.ExtraLabels {
+		merged[k] = v
 	}
+	// Reserved keys always win over ExtraLabels, since selectors elsewhere
+	// in the stack depend on them identifying this stack as Loki.
+	merged["app.kubernetes.io/name"] = "loki"
+	merged["app.kubernetes.io/provider"] = opts.Provider
+	merged["loki.grafana.com/name"] = stackName
+	return merged
 }
 
-func ComponentLabels(component, stackName string) labels.Set {
-	return labels.Merge(commonLabels(stackName), map[string]string{
+func ComponentLabels(component, stackName string, opts Options) labels.Set {
+	return labels.Merge(commonLabels(stackName, opts), map[string]string{
 		"loki.grafana.com/component": component,
 	})
 }
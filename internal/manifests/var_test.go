@@ -0,0 +1,102 @@
+package manifests
+
+import (
+	"strings"
+	"testing"
+)
+
+var testDigest = strings.Repeat("a", 64)
+
+func TestOptionsValidate(t *testing.T) {
+	base := DefaultOptions()
+
+	tests := []struct {
+		name    string
+		mutate  func(Options) Options
+		wantErr bool
+	}{
+		{
+			name:   "defaults are valid",
+			mutate: func(o Options) Options { return o },
+		},
+		{
+			name:    "empty image",
+			mutate:  func(o Options) Options { o.Image = ""; return o },
+			wantErr: true,
+		},
+		{
+			name:   "registry with explicit port",
+			mutate: func(o Options) Options { o.Image = "localhost:5000/grafana/loki:2.1.0"; return o },
+		},
+		{
+			name:   "digest reference",
+			mutate: func(o Options) Options { o.Image = "docker.io/grafana/loki@sha256:" + testDigest; return o },
+		},
+		{
+			name:    "malformed image",
+			mutate:  func(o Options) Options { o.Image = "bad image:tag"; return o },
+			wantErr: true,
+		},
+		{
+			name:    "conflicting ports",
+			mutate:  func(o Options) Options { o.Ports.GRPC = o.Ports.HTTP; return o },
+			wantErr: true,
+		},
+		{
+			name:    "unset port",
+			mutate:  func(o Options) Options { o.Ports.Gossip = 0; return o },
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.mutate(base).Validate()
+			if tt.wantErr && err == nil {
+				t.Fatal("expected an error, got nil")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestCommonLabelsReservedKeysWinOverExtraLabels(t *testing.T) {
+	opts := DefaultOptions()
+	opts.ExtraLabels = map[string]string{
+		"app.kubernetes.io/name":     "not-loki",
+		"app.kubernetes.io/provider": "not-openshift",
+		"loki.grafana.com/name":      "not-the-stack",
+		"team":                       "observability",
+	}
+
+	got := commonLabels("my-stack", opts)
+
+	want := map[string]string{
+		"app.kubernetes.io/name":     "loki",
+		"app.kubernetes.io/provider": opts.Provider,
+		"loki.grafana.com/name":      "my-stack",
+		"team":                       "observability",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("label %q = %q, want %q", k, got[k], v)
+		}
+	}
+}
+
+func TestComponentLabelsIncludesComponentAndStack(t *testing.T) {
+	opts := DefaultOptions()
+	got := ComponentLabels("querier", "my-stack", opts)
+
+	if got["loki.grafana.com/component"] != "querier" {
+		t.Fatalf("expected component label \"querier\", got %q", got["loki.grafana.com/component"])
+	}
+	if got["loki.grafana.com/name"] != "my-stack" {
+		t.Fatalf("expected stack name label \"my-stack\", got %q", got["loki.grafana.com/name"])
+	}
+	if got["app.kubernetes.io/name"] != "loki" {
+		t.Fatalf("expected app.kubernetes.io/name \"loki\", got %q", got["app.kubernetes.io/name"])
+	}
+}
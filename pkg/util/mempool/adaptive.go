@@ -0,0 +1,217 @@
+package mempool
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// defaultAdaptiveInterval is how often an Adaptive MemPool recomputes its
+// buckets when Config.AdaptiveInterval is left at zero.
+const defaultAdaptiveInterval = time.Minute
+
+// adaptivePercentiles are the percentiles of observed request sizes used to
+// derive bucket boundaries on each reconfiguration.
+var adaptivePercentiles = []float64{0.50, 0.75, 0.90, 0.99}
+
+// sizeHistogram counts Get/GetContext requests by the power-of-two class of
+// their requested size, e.g. requests for 300 and 400 bytes both fall in
+// the 512-byte class. It backs Adaptive reconfiguration.
+type sizeHistogram struct {
+	counts [64]int64
+}
+
+func newSizeHistogram() *sizeHistogram {
+	return &sizeHistogram{}
+}
+
+// classOf returns the power-of-two class a size falls into, i.e. the
+// smallest n such that size <= 1<<n.
+func classOf(size int) int {
+	if size <= 1 {
+		return 0
+	}
+	return bits.Len(uint(size - 1))
+}
+
+func (h *sizeHistogram) observe(size int) {
+	class := classOf(size)
+	if class >= len(h.counts) {
+		class = len(h.counts) - 1
+	}
+	atomic.AddInt64(&h.counts[class], 1)
+}
+
+func (h *sizeHistogram) snapshot() []int64 {
+	snap := make([]int64, len(h.counts))
+	for i := range h.counts {
+		snap[i] = atomic.LoadInt64(&h.counts[i])
+	}
+	return snap
+}
+
+func (h *sizeHistogram) reset() {
+	for i := range h.counts {
+		atomic.StoreInt64(&h.counts[i], 0)
+	}
+}
+
+// classAt returns the smallest class whose cumulative count covers at
+// least the p-th fraction (0..1) of all observations in snapshot.
+func classAt(snapshot []int64, p float64) int {
+	var total int64
+	for _, c := range snapshot {
+		total += c
+	}
+	if total == 0 {
+		return 0
+	}
+	target := int64(math.Ceil(p * float64(total)))
+	var cum int64
+	for class, c := range snapshot {
+		cum += c
+		if cum >= target {
+			return class
+		}
+	}
+	return len(snapshot) - 1
+}
+
+// weightsFor aggregates snapshot into one weight per class in classes: each
+// observed class is folded into the smallest class in classes that is
+// greater than or equal to it. Observed classes above the largest class in
+// classes are not counted.
+func weightsFor(snapshot []int64, classes []int) []int64 {
+	weights := make([]int64, len(classes))
+	for class, c := range snapshot {
+		if c == 0 {
+			continue
+		}
+		for i, bc := range classes {
+			if class <= bc {
+				weights[i] += c
+				break
+			}
+		}
+	}
+	return weights
+}
+
+// recomputeBuckets derives new Bucket boundaries from the pool's observed
+// size histogram: it picks a bucket size at each of adaptivePercentiles,
+// rounded up to the next power of two, then spreads the pool's fixed byte
+// budget (a.totalBytes) across them in proportion to how many requests
+// fell into each one. It returns nil if there is nothing to go on yet.
+func (a *MemPool) recomputeBuckets() []Bucket {
+	snapshot := a.histogram.snapshot()
+
+	seen := map[int]bool{}
+	classes := make([]int, 0, len(adaptivePercentiles))
+	for _, p := range adaptivePercentiles {
+		c := classAt(snapshot, p)
+		if !seen[c] {
+			seen[c] = true
+			classes = append(classes, c)
+		}
+	}
+	sort.Ints(classes)
+
+	weights := weightsFor(snapshot, classes)
+	var totalWeight int64
+	for _, w := range weights {
+		totalWeight += w
+	}
+	if totalWeight == 0 {
+		return nil
+	}
+
+	buckets := make([]Bucket, 0, len(classes))
+	for i, c := range classes {
+		size := int64(1) << uint(c)
+		share := float64(weights[i]) / float64(totalWeight)
+		count := int(float64(a.totalBytes) * share / float64(size))
+		if count < 1 {
+			count = 1
+		}
+		buckets = append(buckets, Bucket{
+			Size:          count,
+			Capacity:      size,
+			Timeout:       a.cfg.AdaptiveTimeout,
+			AllowOverflow: a.cfg.AdaptiveAllowOverflow,
+		})
+	}
+	return buckets
+}
+
+// runAdaptive periodically recomputes and applies new buckets until Close
+// is called.
+func (a *MemPool) runAdaptive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+			if buckets := a.recomputeBuckets(); buckets != nil {
+				a.Reconfigure(buckets)
+			}
+			a.histogram.reset()
+		}
+	}
+}
+
+// Reconfigure rebuilds the pool's slabs from buckets and atomically swaps
+// them in, so a concurrent Get/GetContext/Put always sees either the old or
+// the new slab list, never a partially built one. Buffers already checked
+// out against the replaced slabs can still be Put: if their size no longer
+// matches any current slab, Put drops them for the garbage collector
+// instead of erroring. The replaced slabs' fixed-ring buffers are drained
+// asynchronously so they aren't kept alive by an idle channel, and any of
+// their metric label values not reused by the new buckets are deleted so
+// Adaptive mode's ever-changing slab sizes don't accumulate stale series.
+func (a *MemPool) Reconfigure(buckets []Bucket) {
+	old := *a.slabs.Load()
+
+	next := make([]*slab, 0, len(buckets))
+	keep := make(map[string]bool, len(buckets))
+	for _, b := range buckets {
+		s := newSlab(int(b.Capacity), b.Size, b.Timeout, a.metrics)
+		if b.AllowOverflow && a.cfg.MaxOverflowBytes > 0 {
+			s.enableOverflow(&a.allocated, a.cfg.MaxOverflowBytes)
+		}
+		next = append(next, s)
+		keep[s.name] = true
+	}
+	a.slabs.Store(&next)
+	a.metrics.reconfigurationsTotal.Inc()
+
+	for _, s := range old {
+		if !keep[s.name] {
+			a.metrics.deleteSlab(s.name)
+		}
+	}
+
+	go drainSlabs(old)
+}
+
+// drainSlabs empties replaced slabs' fixed-ring channels so their buffers
+// become eligible for garbage collection instead of sitting unread.
+func drainSlabs(slabs []*slab) {
+	for _, s := range slabs {
+		if s.buffer == nil {
+			continue
+		}
+	drain:
+		for {
+			select {
+			case <-s.buffer:
+			default:
+				break drain
+			}
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package mempool
+
+import "context"
+
+// Allocator hands out []byte buffers of a requested size and takes them
+// back once callers are done with them, so the underlying memory can be
+// re-used instead of being garbage collected.
+type Allocator interface {
+	// Get returns a buffer of the given size, or an error if none is
+	// available right away.
+	Get(size int) ([]byte, error)
+	// GetContext returns a buffer of the given size, blocking until one
+	// becomes available, ctx is cancelled, or an implementation-defined
+	// wait bound is exceeded.
+	GetContext(ctx context.Context, size int) ([]byte, error)
+	// Put returns a buffer previously obtained from Get or GetContext
+	// back to the allocator.
+	Put(buffer []byte) bool
+}
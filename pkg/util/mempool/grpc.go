@@ -0,0 +1,43 @@
+package mempool
+
+import "google.golang.org/grpc/mem"
+
+// grpcPool adapts a MemPool to the grpc-go mem.BufferPool interface
+// (see google.golang.org/grpc/mem and grpc.WithSharedBufferPool /
+// grpc.SharedBufferPool), so message framing buffers can be recycled from
+// the same slabs used elsewhere in the pool, e.g. for chunk decoding.
+type grpcPool struct {
+	*MemPool
+}
+
+// GRPCPool returns a mem.BufferPool backed by a's slabs. Lengths that
+// exceed the largest slab fall back to a plain make([]byte, length), since
+// mem.BufferPool has no way to report an allocation failure.
+//
+// TODO: wire this into the querier/ingester/distributor gRPC dial and
+// server options via grpc.WithSharedBufferPool / grpc.SharedBufferPool, so
+// the chunk-decoding slabs are actually shared with gRPC message framing.
+// That construction code (where client conns and servers get built) does
+// not exist anywhere in this tree yet, so there is nothing to call
+// GRPCPool() from; tracked as a follow-up for whichever package ends up
+// owning it rather than left silently unwired.
+func (a *MemPool) GRPCPool() mem.BufferPool {
+	return grpcPool{a}
+}
+
+// Get satisfies mem.BufferPool, returning a buffer from the smallest slab
+// that can hold length bytes.
+func (p grpcPool) Get(length int) *[]byte {
+	buf, err := p.MemPool.Get(length)
+	if err != nil {
+		buf = make([]byte, length)
+	}
+	return &buf
+}
+
+// Put satisfies mem.BufferPool. Buffers that didn't come from one of the
+// pool's slabs, e.g. the oversized fallback allocated by Get, are simply
+// left for the garbage collector.
+func (p grpcPool) Put(buf *[]byte) {
+	p.MemPool.Put(*buf)
+}
@@ -0,0 +1,115 @@
+package mempool
+
+import "github.com/prometheus/client_golang/prometheus"
+
+type metrics struct {
+	availableBuffersPerSlab *prometheus.GaugeVec
+	inuseBuffersPerSlab     *prometheus.GaugeVec
+	getWaitSeconds          *prometheus.HistogramVec
+	waitExceededTotal       *prometheus.CounterVec
+	errorsCounter           *prometheus.CounterVec
+	allocatedBytes          prometheus.Gauge
+	overflowBuffers         *prometheus.GaugeVec
+	overflowRefusedTotal    *prometheus.CounterVec
+	reconfigurationsTotal   prometheus.Counter
+}
+
+func newMetrics(r prometheus.Registerer, name string) *metrics {
+	m := &metrics{
+		availableBuffersPerSlab: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   "loki",
+			Subsystem:   "mempool",
+			Name:        "available_buffers",
+			Help:        "Number of buffers available for immediate use in a given slab.",
+			ConstLabels: prometheus.Labels{"pool": name},
+		}, []string{"slab"}),
+		inuseBuffersPerSlab: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   "loki",
+			Subsystem:   "mempool",
+			Name:        "inuse_buffers",
+			Help:        "Number of buffers currently checked out of a given slab.",
+			ConstLabels: prometheus.Labels{"pool": name},
+		}, []string{"slab"}),
+		getWaitSeconds: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   "loki",
+			Subsystem:   "mempool",
+			Name:        "get_wait_seconds",
+			Help:        "Time GetContext spent waiting for a buffer to become available.",
+			ConstLabels: prometheus.Labels{"pool": name},
+			Buckets:     prometheus.DefBuckets,
+		}, []string{"slab"}),
+		waitExceededTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   "loki",
+			Subsystem:   "mempool",
+			Name:        "wait_exceeded_total",
+			Help:        "Number of GetContext calls that waited longer than the warning threshold for a buffer.",
+			ConstLabels: prometheus.Labels{"pool": name},
+		}, []string{"slab"}),
+		errorsCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   "loki",
+			Subsystem:   "mempool",
+			Name:        "errors_total",
+			Help:        "Number of errors encountered while allocating buffers, by reason.",
+			ConstLabels: prometheus.Labels{"pool": name},
+		}, []string{"slab", "reason"}),
+		allocatedBytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   "loki",
+			Subsystem:   "mempool",
+			Name:        "allocated_bytes",
+			Help:        "Total bytes currently allocated as overflow buffers across all slabs.",
+			ConstLabels: prometheus.Labels{"pool": name},
+		}),
+		overflowBuffers: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   "loki",
+			Subsystem:   "mempool",
+			Name:        "overflow_buffers",
+			Help:        "Number of overflow buffers currently checked out of a given slab.",
+			ConstLabels: prometheus.Labels{"pool": name},
+		}, []string{"slab"}),
+		overflowRefusedTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   "loki",
+			Subsystem:   "mempool",
+			Name:        "overflow_refused_total",
+			Help:        "Number of overflow allocations refused because MaxOverflowBytes was reached.",
+			ConstLabels: prometheus.Labels{"pool": name},
+		}, []string{"slab"}),
+		reconfigurationsTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   "loki",
+			Subsystem:   "mempool",
+			Name:        "reconfigurations_total",
+			Help:        "Number of times the pool's buckets were rebuilt, whether by Adaptive mode or a manual Reconfigure call.",
+			ConstLabels: prometheus.Labels{"pool": name},
+		}),
+	}
+	if r != nil {
+		r.MustRegister(
+			m.availableBuffersPerSlab,
+			m.inuseBuffersPerSlab,
+			m.getWaitSeconds,
+			m.waitExceededTotal,
+			m.errorsCounter,
+			m.allocatedBytes,
+			m.overflowBuffers,
+			m.overflowRefusedTotal,
+			m.reconfigurationsTotal,
+		)
+	}
+	return m
+}
+
+// deleteSlab removes every series labelled with the given slab name. It is
+// called for slabs dropped by Reconfigure so that, in Adaptive mode, a size
+// class that stops occurring doesn't leave its label values registered
+// forever: each reconfiguration derives slab sizes from live percentiles, so
+// over the life of the process that would otherwise be unbounded label
+// cardinality.
+func (m *metrics) deleteSlab(name string) {
+	m.availableBuffersPerSlab.DeleteLabelValues(name)
+	m.inuseBuffersPerSlab.DeleteLabelValues(name)
+	m.getWaitSeconds.DeleteLabelValues(name)
+	m.waitExceededTotal.DeleteLabelValues(name)
+	m.errorsCounter.DeleteLabelValues(name, reasonSlabExhausted)
+	m.errorsCounter.DeleteLabelValues(name, reasonSizeExceeded)
+	m.overflowBuffers.DeleteLabelValues(name)
+	m.overflowRefusedTotal.DeleteLabelValues(name)
+}
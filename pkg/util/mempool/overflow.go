@@ -0,0 +1,101 @@
+package mempool
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// overflowBuffer is a sync.Pool-recycled buffer handed out once a slab's
+// fixed ring is exhausted. Buffers allocated this way are tracked in the
+// slab's overflowIndex, keyed by uintptr rather than unsafe.Pointer, so the
+// index itself never keeps a buffer reachable: if a caller drops an
+// overflow buffer instead of calling Put, the backing array becomes
+// unreachable and the finalizer set in getOverflow still decrements the
+// pool-wide allocated counter so it doesn't leak. Put tells overflow
+// buffers apart from ring buffers via the same index and returns them to
+// the sync.Pool instead of the channel.
+type overflowBuffer struct {
+	buf []byte
+}
+
+// enableOverflow wires up a slab to allocate overflow buffers from a
+// sync.Pool once its fixed ring is exhausted, up to maxBytes shared across
+// every slab in the pool via allocated.
+func (s *slab) enableOverflow(allocated *int64, maxBytes int64) {
+	s.allowOverflow = true
+	s.allocated = allocated
+	s.maxOverflowBytes = maxBytes
+	size := s.size
+	s.overflowPool = &sync.Pool{
+		New: func() interface{} {
+			return &overflowBuffer{buf: make([]byte, 0, size)}
+		},
+	}
+}
+
+// getOverflow allocates an overflow buffer sized for size, or reports
+// ok=false if overflow is disabled for this slab or MaxOverflowBytes has
+// been reached.
+func (s *slab) getOverflow(size int) (buf []byte, ok bool) {
+	if !s.allowOverflow {
+		return nil, false
+	}
+
+	total := atomic.AddInt64(s.allocated, int64(s.size))
+	if total > s.maxOverflowBytes {
+		total = atomic.AddInt64(s.allocated, -int64(s.size))
+		s.metrics.overflowRefusedTotal.WithLabelValues(s.name).Inc()
+		return nil, false
+	}
+	s.metrics.allocatedBytes.Set(float64(total))
+
+	ob := s.overflowPool.Get().(*overflowBuffer)
+	if cap(ob.buf) < s.size {
+		ob.buf = make([]byte, 0, s.size)
+	}
+	full := ob.buf[:s.size]
+	zero(full)
+
+	// Key the index by the backing array's address as a plain uintptr, not
+	// an unsafe.Pointer/*overflowBuffer: either of those would be a GC root
+	// that keeps the array reachable for as long as the index entry
+	// exists, which would stop the finalizer below from ever firing for a
+	// buffer the caller drops instead of returning via Put.
+	ptr := unsafe.SliceData(full)
+	key := uintptr(unsafe.Pointer(ptr))
+	s.overflowIndex.Store(key, struct{}{})
+
+	slabSize, allocated, index, metrics, name := s.size, s.allocated, &s.overflowIndex, s.metrics, s.name
+	runtime.SetFinalizer(ptr, func(*byte) {
+		if _, dropped := index.LoadAndDelete(key); dropped {
+			metrics.allocatedBytes.Set(float64(atomic.AddInt64(allocated, -int64(slabSize))))
+			metrics.overflowBuffers.WithLabelValues(name).Dec()
+		}
+	})
+
+	s.metrics.overflowBuffers.WithLabelValues(s.name).Inc()
+	return full[:size], true
+}
+
+// putOverflow returns buf to the slab's sync.Pool if it is an overflow
+// buffer, reporting whether it handled it; the caller falls back to the
+// fixed ring channel when it returns false.
+func (s *slab) putOverflow(buf []byte) bool {
+	if s.overflowPool == nil {
+		return false
+	}
+
+	ptr := unsafe.SliceData(buf)
+	key := uintptr(unsafe.Pointer(ptr))
+	if _, ok := s.overflowIndex.LoadAndDelete(key); !ok {
+		return false
+	}
+
+	runtime.SetFinalizer(ptr, nil)
+	s.metrics.allocatedBytes.Set(float64(atomic.AddInt64(s.allocated, -int64(s.size))))
+	s.metrics.overflowBuffers.WithLabelValues(s.name).Dec()
+	s.overflowPool.Put(&overflowBuffer{buf: buf[:0:cap(buf)]})
+	return true
+}
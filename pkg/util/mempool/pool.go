@@ -1,13 +1,19 @@
 package mempool
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 	"unsafe"
 
 	"github.com/dustin/go-humanize"
+	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
+
+	util_log "github.com/grafana/loki/pkg/util/log"
 )
 
 var (
@@ -17,23 +23,41 @@ var (
 	reasonSlabExhausted = "slab-exhausted"
 )
 
+// getWaitWarnThreshold is the wait incurred by a GetContext call above which
+// we consider the pool to be under enough back-pressure to warrant a log
+// line, in addition to the mempool_get_wait_seconds histogram.
+const getWaitWarnThreshold = 500 * time.Millisecond
+
 type slab struct {
 	buffer      chan unsafe.Pointer
 	size, count int
-	mtx         sync.Mutex
-	metrics     *metrics
-	name        string
+	// getTimeout bounds how long GetContext will wait for a buffer on top
+	// of the caller-supplied context, zero means "wait for ctx only".
+	getTimeout time.Duration
+	mtx        sync.Mutex
+	metrics    *metrics
+	name       string
+
+	// overflow allocation, see overflow.go. allowOverflow is set once at
+	// construction time; the rest are only touched when it is true.
+	allowOverflow    bool
+	allocated        *int64
+	maxOverflowBytes int64
+	overflowPool     *sync.Pool
+	overflowIndex    sync.Map
 }
 
-func newSlab(bufferSize, bufferCount int, m *metrics) *slab {
+func newSlab(bufferSize, bufferCount int, getTimeout time.Duration, m *metrics) *slab {
 	name := humanize.Bytes(uint64(bufferSize))
 	m.availableBuffersPerSlab.WithLabelValues(name).Add(0) // initialize metric with value 0
+	m.inuseBuffersPerSlab.WithLabelValues(name).Add(0)     // initialize metric with value 0
 
 	return &slab{
-		size:    bufferSize,
-		count:   bufferCount,
-		metrics: m,
-		name:    name,
+		size:       bufferSize,
+		count:      bufferCount,
+		getTimeout: getTimeout,
+		metrics:    m,
+		name:       name,
 	}
 }
 
@@ -60,29 +84,142 @@ func (s *slab) get(size int) ([]byte, error) {
 	case ptr := <-s.buffer:
 		buf = unsafe.Slice((*byte)(ptr), s.size)
 	default:
+		if ob, ok := s.getOverflow(size); ok {
+			return ob, nil
+		}
 		s.metrics.errorsCounter.WithLabelValues(s.name, reasonSlabExhausted).Inc()
 		return nil, errSlabExhausted
 	}
+	s.updateInuseMetric()
 
-	// Taken from https://github.com/ortuman/nuke/blob/main/monotonic_arena.go#L37-L48
-	// This piece of code will be translated into a runtime.memclrNoHeapPointers
-	// invocation by the compiler, which is an assembler optimized implementation.
-	// Architecture specific code can be found at src/runtime/memclr_$GOARCH.s
-	// in Go source (since https://codereview.appspot.com/137880043).
-	for i := range buf {
-		buf[i] = 0
+	zero(buf)
+
+	return buf[:size], nil
+}
+
+// getContext blocks until a buffer becomes available, ctx is done, or
+// getTimeout elapses, whichever happens first. Unlike get it never returns
+// errSlabExhausted; instead exhaustion shows up as a blocked caller, which
+// is tracked via the mempool_get_wait_seconds histogram and, past
+// getWaitWarnThreshold, the mempool_wait_exceeded_total counter.
+func (s *slab) getContext(ctx context.Context, size int) ([]byte, error) {
+	s.mtx.Lock()
+	if s.buffer == nil {
+		s.init()
+	}
+	s.mtx.Unlock()
+
+	select {
+	case ptr := <-s.buffer:
+		s.updateInuseMetric()
+		buf := unsafe.Slice((*byte)(ptr), s.size)
+		zero(buf)
+		return buf[:size], nil
+	default:
+	}
+
+	if buf, ok := s.getOverflow(size); ok {
+		return buf, nil
+	}
+
+	waitCtx := ctx
+	if s.getTimeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, s.getTimeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	var ptr unsafe.Pointer
+	select {
+	case ptr = <-s.buffer:
+	case <-waitCtx.Done():
+		s.metrics.errorsCounter.WithLabelValues(s.name, reasonSlabExhausted).Inc()
+		return nil, waitCtx.Err()
+	}
+
+	if wait := time.Since(start); wait > 0 {
+		s.metrics.getWaitSeconds.WithLabelValues(s.name).Observe(wait.Seconds())
+		if wait > getWaitWarnThreshold {
+			s.metrics.waitExceededTotal.WithLabelValues(s.name).Inc()
+			level.Warn(util_log.Logger).Log("msg", "reached max buffers, waiting", "slab", s.name, "wait", wait)
+		}
 	}
+	s.updateInuseMetric()
+
+	buf := unsafe.Slice((*byte)(ptr), s.size)
+	zero(buf)
 
 	return buf[:size], nil
 }
 
 func (s *slab) put(buf []byte) {
+	if s.putOverflow(buf) {
+		return
+	}
+
 	if s.buffer == nil {
 		panic("slab is not initialized")
 	}
 
 	ptr := unsafe.Pointer(unsafe.SliceData(buf))
 	s.buffer <- ptr
+	s.updateInuseMetric()
+}
+
+// updateInuseMetric reports how many buffers are currently checked out of
+// the slab, i.e. not sitting in s.buffer waiting to be handed out.
+func (s *slab) updateInuseMetric() {
+	s.metrics.inuseBuffersPerSlab.WithLabelValues(s.name).Set(float64(s.count - len(s.buffer)))
+}
+
+// zero clears buf so callers never observe data left over by a previous
+// user of the underlying array.
+//
+// Taken from https://github.com/ortuman/nuke/blob/main/monotonic_arena.go#L37-L48
+// This piece of code will be translated into a runtime.memclrNoHeapPointers
+// invocation by the compiler, which is an assembler optimized implementation.
+// Architecture specific code can be found at src/runtime/memclr_$GOARCH.s
+// in Go source (since https://codereview.appspot.com/137880043).
+func zero(buf []byte) {
+	for i := range buf {
+		buf[i] = 0
+	}
+}
+
+// Bucket configures a single slab of the pool: Size buffers of Capacity
+// bytes each are pre-allocated up front. Timeout bounds how long
+// GetContext will wait for a buffer of this slab to free up once it is
+// exhausted, on top of whatever deadline the caller's context carries; zero
+// means wait for the context alone. AllowOverflow lets this slab fall back
+// to elastic sync.Pool-backed buffers, governed by Config.MaxOverflowBytes,
+// instead of blocking or erroring once its fixed ring is exhausted.
+type Bucket struct {
+	Size          int
+	Capacity      int64
+	Timeout       time.Duration
+	AllowOverflow bool
+}
+
+// Config holds pool-wide settings that apply across all slabs of a MemPool.
+type Config struct {
+	// MaxOverflowBytes bounds the total size of overflow buffers
+	// outstanding at any time across every slab with AllowOverflow set.
+	// Zero disables overflow allocation for the whole pool.
+	MaxOverflowBytes int64
+
+	// Adaptive enables periodic reconfiguration of the pool's buckets
+	// based on observed Get/GetContext request sizes, see adaptive.go.
+	Adaptive bool
+	// AdaptiveInterval is how often the pool recomputes its buckets in
+	// Adaptive mode. Defaults to defaultAdaptiveInterval if zero.
+	AdaptiveInterval time.Duration
+	// AdaptiveTimeout and AdaptiveAllowOverflow are applied to every
+	// bucket Adaptive mode derives from the observed size histogram,
+	// since a computed Bucket has no original Timeout/AllowOverflow of
+	// its own to carry forward.
+	AdaptiveTimeout       time.Duration
+	AdaptiveAllowOverflow bool
 }
 
 // MemPool is an Allocator implementation that uses a fixed size memory pool
@@ -90,30 +227,89 @@ func (s *slab) put(buf []byte) {
 // Buffers are re-cycled and need to be returned back to the pool, otherwise
 // the pool runs out of available buffers.
 type MemPool struct {
-	slabs   []*slab
-	metrics *metrics
+	slabs     atomic.Pointer[[]*slab]
+	metrics   *metrics
+	allocated int64
+
+	cfg        Config
+	histogram  *sizeHistogram
+	totalBytes int64
+	stop       chan struct{}
 }
 
-func New(name string, buckets []Bucket, r prometheus.Registerer) *MemPool {
+func New(name string, buckets []Bucket, cfg Config, r prometheus.Registerer) *MemPool {
 	a := &MemPool{
-		slabs:   make([]*slab, 0, len(buckets)),
 		metrics: newMetrics(r, name),
+		cfg:     cfg,
 	}
+
+	slabs := make([]*slab, 0, len(buckets))
 	for _, b := range buckets {
-		a.slabs = append(a.slabs, newSlab(int(b.Capacity), b.Size, a.metrics))
+		s := newSlab(int(b.Capacity), b.Size, b.Timeout, a.metrics)
+		if b.AllowOverflow && cfg.MaxOverflowBytes > 0 {
+			s.enableOverflow(&a.allocated, cfg.MaxOverflowBytes)
+		}
+		slabs = append(slabs, s)
+		a.totalBytes += b.Capacity * int64(b.Size)
 	}
+	a.slabs.Store(&slabs)
+
+	if cfg.Adaptive {
+		a.histogram = newSizeHistogram()
+		a.stop = make(chan struct{})
+		interval := cfg.AdaptiveInterval
+		if interval <= 0 {
+			interval = defaultAdaptiveInterval
+		}
+		go a.runAdaptive(interval)
+	}
+
 	return a
 }
 
+// Close stops the background goroutine started in Adaptive mode. It is a
+// no-op otherwise.
+func (a *MemPool) Close() {
+	if a.stop != nil {
+		close(a.stop)
+	}
+}
+
 // Get satisfies Allocator interface
 // Allocating a buffer from an exhausted pool/slab, or allocating a buffer that
 // exceeds the largest slab size will return an error.
+// A slab with Bucket.AllowOverflow set hands out an overflow buffer instead
+// of erroring when exhausted, until Config.MaxOverflowBytes is reached.
 func (a *MemPool) Get(size int) ([]byte, error) {
-	for i := 0; i < len(a.slabs); i++ {
-		if a.slabs[i].size < size {
+	if a.histogram != nil {
+		a.histogram.observe(size)
+	}
+	slabs := *a.slabs.Load()
+	for i := 0; i < len(slabs); i++ {
+		if slabs[i].size < size {
+			continue
+		}
+		return slabs[i].get(size)
+	}
+	a.metrics.errorsCounter.WithLabelValues("pool", reasonSizeExceeded).Inc()
+	return nil, fmt.Errorf("no slab found for size: %d", size)
+}
+
+// GetContext satisfies Allocator interface.
+// Unlike Get, it blocks on an exhausted slab until a buffer is returned,
+// ctx is done, or the slab's configured wait timeout elapses, instead of
+// immediately returning errSlabExhausted. Allocating a buffer that exceeds
+// the largest slab size still returns an error right away.
+func (a *MemPool) GetContext(ctx context.Context, size int) ([]byte, error) {
+	if a.histogram != nil {
+		a.histogram.observe(size)
+	}
+	slabs := *a.slabs.Load()
+	for i := 0; i < len(slabs); i++ {
+		if slabs[i].size < size {
 			continue
 		}
-		return a.slabs[i].get(size)
+		return slabs[i].getContext(ctx, size)
 	}
 	a.metrics.errorsCounter.WithLabelValues("pool", reasonSizeExceeded).Inc()
 	return nil, fmt.Errorf("no slab found for size: %d", size)
@@ -122,13 +318,24 @@ func (a *MemPool) Get(size int) ([]byte, error) {
 // Put satisfies Allocator interface
 // Every buffer allocated with Get(size int) needs to be returned to the pool
 // using Put(buffer []byte) so it can be re-cycled.
+//
+// Put requires an exact cap(buffer) == slab size match, not merely a slab
+// large enough to hold it: a buffer is only ever safe to feed into a slab
+// whose size equals its own capacity, since get/getContext reinterpret the
+// backing array as exactly slab.size bytes via unsafe.Slice before zeroing
+// it. A buffer whose size no longer matches any current slab, because the
+// pool was reconfigured (see Reconfigure) after the buffer was handed out,
+// is simply dropped so it can be garbage collected, instead of being
+// accepted into an oversized slab where it would be reinterpreted past the
+// end of its actual allocation.
 func (a *MemPool) Put(buffer []byte) bool {
 	size := cap(buffer)
-	for i := 0; i < len(a.slabs); i++ {
-		if a.slabs[i].size < size {
+	slabs := *a.slabs.Load()
+	for i := 0; i < len(slabs); i++ {
+		if slabs[i].size != size {
 			continue
 		}
-		a.slabs[i].put(buffer)
+		slabs[i].put(buffer)
 		return true
 	}
 	return false
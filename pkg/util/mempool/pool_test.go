@@ -0,0 +1,189 @@
+package mempool
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMemPool_GetContext_TimesOutOnExhaustedSlab(t *testing.T) {
+	p := New("test", []Bucket{{Size: 1, Capacity: 16}}, Config{}, nil)
+
+	buf, err := p.Get(8)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := p.GetContext(ctx, 8); err == nil {
+		t.Fatal("expected GetContext to time out on an exhausted slab")
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Fatalf("GetContext returned before its timeout elapsed: %s", elapsed)
+	}
+
+	p.Put(buf)
+}
+
+func TestMemPool_GetContext_ReturnsOnCancelledContext(t *testing.T) {
+	p := New("test", []Bucket{{Size: 1, Capacity: 16}}, Config{}, nil)
+
+	if _, err := p.Get(8); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := p.GetContext(ctx, 8); err == nil {
+		t.Fatal("expected GetContext to return once ctx is already cancelled")
+	}
+}
+
+func TestMemPool_GetContext_UnblocksOnPut(t *testing.T) {
+	p := New("test", []Bucket{{Size: 1, Capacity: 16}}, Config{}, nil)
+
+	buf, err := p.Get(8)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := p.GetContext(context.Background(), 8)
+		done <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	p.Put(buf)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("GetContext: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("GetContext did not unblock after Put")
+	}
+}
+
+func TestMemPool_Overflow_EnforcesMaxOverflowBytes(t *testing.T) {
+	p := New("test", []Bucket{{Size: 1, Capacity: 16, AllowOverflow: true}}, Config{MaxOverflowBytes: 16}, nil)
+
+	ring, err := p.Get(8)
+	if err != nil {
+		t.Fatalf("Get (ring buffer): %v", err)
+	}
+
+	overflow, err := p.Get(8)
+	if err != nil {
+		t.Fatalf("expected one overflow allocation within the cap, got: %v", err)
+	}
+
+	if _, err := p.Get(8); err == nil {
+		t.Fatal("expected a second overflow allocation to be refused once MaxOverflowBytes is reached")
+	}
+
+	p.Put(overflow)
+	p.Put(ring)
+
+	// Once the overflow buffer above is returned, the cap has headroom
+	// again.
+	again, err := p.Get(8)
+	if err != nil {
+		t.Fatalf("Get after Put: %v", err)
+	}
+	p.Put(again)
+}
+
+func TestMemPool_Reconfigure_ConcurrentGetPut(t *testing.T) {
+	p := New("test", []Bucket{{Size: 4, Capacity: 16}}, Config{}, nil)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if buf, err := p.Get(8); err == nil {
+					p.Put(buf)
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 5; i++ {
+		p.Reconfigure([]Bucket{{Size: 4, Capacity: 32}})
+	}
+
+	close(stop)
+	wg.Wait()
+}
+
+func TestMemPool_Put_DropsBufferFromReplacedSlab(t *testing.T) {
+	p := New("test", []Bucket{{Size: 1, Capacity: 16}}, Config{}, nil)
+
+	buf, err := p.Get(8)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	// buf's backing array is still only 16 bytes; the reconfigured slab's
+	// size is 32. Put must not accept it into the new, larger slab, since
+	// get/getContext would later reinterpret those 16 bytes as 32 and zero
+	// past the end of the actual allocation.
+	p.Reconfigure([]Bucket{{Size: 1, Capacity: 32}})
+
+	if ok := p.Put(buf); ok {
+		t.Fatal("expected Put to drop a buffer whose size no longer matches any current slab")
+	}
+
+	buf2, err := p.Get(8)
+	if err != nil {
+		t.Fatalf("Get after Reconfigure: %v", err)
+	}
+	if cap(buf2) != 32 {
+		t.Fatalf("expected the new slab's buffer capacity to be 32, got %d", cap(buf2))
+	}
+	p.Put(buf2)
+}
+
+func TestMemPool_Reconfigure_DeletesStaleSlabLabels(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	p := New("test", []Bucket{{Size: 1, Capacity: 16}}, Config{}, reg)
+
+	if _, err := p.Get(8); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	p.Reconfigure([]Bucket{{Size: 1, Capacity: 32}})
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	for _, f := range families {
+		if f.GetName() != "loki_mempool_available_buffers" {
+			continue
+		}
+		for _, m := range f.Metric {
+			for _, l := range m.Label {
+				if l.GetName() == "slab" && l.GetValue() == "16 B" {
+					t.Fatalf("expected the replaced 16B slab's label to be deleted, still present: %v", m)
+				}
+			}
+		}
+	}
+}